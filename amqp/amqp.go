@@ -3,13 +3,21 @@ package amqp
 import (
 	"context"
 	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/cyverse-de/messaging/v9"
+	"github.com/cyverse-de/qms-adapter/httpsrv"
 	"github.com/cyverse-de/qms-adapter/logging"
+	"github.com/cyverse-de/qms-adapter/qms"
 	"github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
 )
 
+// consumerTag identifies this service's consumer so it can be cancelled individually on
+// shutdown, ahead of closing the connection outright.
+const consumerTag = "qms-adapter"
+
 var log = logging.Log.WithFields(logrus.Fields{"package": "amqp"})
 
 // Configuration contains the AMQP settings.
@@ -21,6 +29,34 @@ type Configuration struct {
 	RoutingKey    string
 	Queue         string
 	PrefetchCount int
+
+	// BatchSize is the number of updates folded into a single QMS batch request.
+	BatchSize int
+
+	// BatchWindow is the longest a partial batch is held before being flushed anyway.
+	BatchWindow time.Duration
+
+	// MaxRetries is the number of additional attempts made to submit a batch before it is
+	// dead-lettered.
+	MaxRetries int
+
+	// DeadLetterExchange, if set, is declared as the queue's x-dead-letter-exchange, so that
+	// batches which exhaust their retries are routed there instead of being lost.
+	DeadLetterExchange string
+
+	// Workers is the number of goroutines submitting batches to QMS concurrently.
+	Workers int
+
+	// DispatchQueueSize is how many flushed batches may be queued for a worker before the
+	// batcher blocks.
+	DispatchQueueSize int
+
+	// TLSCACert, TLSClientCert, and TLSClientKey are paths to PEM-encoded files. If any of them,
+	// or InsecureSkipVerify, are set, the broker connection is dialed with TLS.
+	TLSCACert          string
+	TLSClientCert      string
+	TLSClientKey       string
+	InsecureSkipVerify bool
 }
 
 // QMSUpdate contains the information sent to the QMS service.
@@ -30,45 +66,116 @@ type QMSUpdate struct {
 	Unit      string `json:"unit"`
 	UserID    string `json:"user_id"`
 	Username  string `json:"username"`
+
+	// Operation is one of "set", "add", or "sub", and controls how QMS applies UsageValue to the
+	// resource's existing usage. It defaults to "set" when omitted.
+	Operation string `json:"operation,omitempty"`
+
+	// EffectiveDate, if set, is forwarded to QMS as-is so that back-dated corrections land
+	// against the right billing period instead of the time the message was processed.
+	EffectiveDate string `json:"effective_date,omitempty"`
 }
 
-// HandlerFn is the function signature for QMS update handlers.
-type HandlerFn func(context.Context, *QMSUpdate)
+// operationUpdateTypes maps the operations accepted on an incoming QMSUpdate to the update_type
+// values QMS understands.
+var operationUpdateTypes = map[string]string{
+	"set": "SET",
+	"add": "ADD",
+	"sub": "SUB",
+}
+
+// UpdateType returns the QMS update_type for this update's Operation, defaulting to "SET" when
+// Operation is unset. The second return value is false when Operation is set but unrecognized.
+func (u *QMSUpdate) UpdateType() (string, bool) {
+	op := u.Operation
+	if op == "" {
+		op = "set"
+	}
+
+	updateType, ok := operationUpdateTypes[strings.ToLower(op)]
+	return updateType, ok
+}
+
+// ParseValue parses this update's Value as the float64 QMS expects.
+func (u *QMSUpdate) ParseValue() (float64, error) {
+	return strconv.ParseFloat(u.Value, 64)
+}
 
 // AMQP encapsulates the logic for handling AMQP messages.
 type AMQP struct {
-	client  *messaging.Client
-	handler HandlerFn
+	conn        *amqp.Connection
+	ch          *amqp.Channel
+	batcher     *batcher
+	dispatcher  *qms.Dispatcher
+	events      chan Event
+	consumeDone chan struct{}
 }
 
-// New returns a new *AMQP based on the configuration and HandlerFn passed in.
-func New(config *Configuration, handler HandlerFn) (*AMQP, error) {
-	log.Debug("creating a new AMQP client")
-	client, err := messaging.NewClient(config.URI, config.Reconnect)
+// New returns a new *AMQP based on the configuration and BatchHandlerFn passed in.
+func New(config *Configuration, handler BatchHandlerFn) (*AMQP, error) {
+	conn, err := dial(config)
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = ch.ExchangeDeclare(config.Exchange, config.ExchangeType, true, false, false, false, nil); err != nil {
+		return nil, err
+	}
+
+	queueArgs := amqp.Table{}
+	if config.DeadLetterExchange != "" {
+		queueArgs["x-dead-letter-exchange"] = config.DeadLetterExchange
+	}
+
+	queue, err := ch.QueueDeclare(config.Queue, true, false, false, false, queueArgs)
 	if err != nil {
 		return nil, err
 	}
-	log.Debug("done creating a new AMQP client")
+
+	if err = ch.QueueBind(queue.Name, config.RoutingKey, config.Exchange, false, nil); err != nil {
+		return nil, err
+	}
+
+	if err = ch.Qos(config.PrefetchCount, 0, false); err != nil {
+		return nil, err
+	}
+
+	deliveries, err := ch.Consume(queue.Name, consumerTag, false, false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	dispatcher := qms.NewDispatcher(config.Workers, config.DispatchQueueSize)
+
+	b := newBatcher(handler, config.BatchSize, config.BatchWindow, dispatcher)
+	go b.run()
 
 	a := &AMQP{
-		client:  client,
-		handler: handler,
+		conn:        conn,
+		ch:          ch,
+		batcher:     b,
+		dispatcher:  dispatcher,
+		events:      make(chan Event, 16),
+		consumeDone: make(chan struct{}),
 	}
 
-	go a.client.Listen()
+	a.watchConnection()
 
-	log.Debug("adding a consumer")
-	client.AddConsumer(
-		config.Exchange,
-		config.ExchangeType,
-		config.Queue,
-		config.RoutingKey,
-		a.recv,
-		config.PrefetchCount,
-	)
-	log.Debug("done adding a consumer")
+	go a.consume(deliveries)
+
+	return a, nil
+}
 
-	return a, err
+func (a *AMQP) consume(deliveries <-chan amqp.Delivery) {
+	defer close(a.consumeDone)
+	for delivery := range deliveries {
+		a.recv(context.Background(), delivery)
+	}
 }
 
 func (a *AMQP) recv(ctx context.Context, delivery amqp.Delivery) {
@@ -83,25 +190,69 @@ func (a *AMQP) recv(ctx context.Context, delivery amqp.Delivery) {
 		delivery.RoutingKey,
 		string(delivery.Body),
 	)
-
-	if err = delivery.Ack(false); err != nil {
-		log.Error(err)
-		return
-	}
+	httpsrv.MessagesReceived.Inc()
 
 	redelivered := delivery.Redelivered
 	if err = json.Unmarshal(delivery.Body, &update); err != nil {
 		log.Error(err)
+		if redelivered {
+			httpsrv.MessagesDeadLettered.Inc()
+		}
 		if err = delivery.Reject(!redelivered); err != nil {
 			log.Error(err)
 		}
 		return
 	}
 
-	a.handler(ctx, &update)
+	if _, ok := update.UpdateType(); !ok {
+		log.Errorf("unknown operation %q, dead-lettering message", update.Operation)
+		httpsrv.MessagesDeadLettered.Inc()
+		if err = delivery.Reject(false); err != nil {
+			log.Error(err)
+		}
+		return
+	}
+
+	if _, err = update.ParseValue(); err != nil {
+		log.Errorf("unparseable value %q, dead-lettering message: %s", update.Value, err)
+		httpsrv.MessagesDeadLettered.Inc()
+		if err = delivery.Reject(false); err != nil {
+			log.Error(err)
+		}
+		return
+	}
+
+	httpsrv.MessagesDecoded.Inc()
+	a.batcher.enqueue(pendingUpdate{delivery: delivery, update: &update})
 }
 
-// Close closes the connection to the AMQP broker.
+// Drain stops this service's consumer so no further deliveries arrive, waits for any delivery
+// already handed to consume to finish being enqueued, flushes any partial batch, and waits for
+// the dispatcher's workers to finish in-flight and queued batches, up to ctx's deadline. Call it
+// before Close so acks for in-flight work still have a connection to go out on.
+func (a *AMQP) Drain(ctx context.Context) error {
+	if err := a.ch.Cancel(consumerTag, false); err != nil {
+		log.Error(err)
+	}
+
+	// Cancel only guarantees deliveries stop arriving on the channel eventually, not that
+	// consume has finished handling the ones already in flight. Wait for it to exit before
+	// stopping the batcher, or a delivery still mid-enqueue when run exits would block forever
+	// on b.in, leaking the goroutine and leaving that delivery unacked.
+	select {
+	case <-a.consumeDone:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	a.batcher.stop()
+
+	return a.dispatcher.Close(ctx)
+}
+
+// Close closes the connection to the broker.
 func (a *AMQP) Close() {
-	a.client.Close()
+	if err := a.conn.Close(); err != nil {
+		log.Error(err)
+	}
 }
@@ -0,0 +1,65 @@
+package amqp
+
+import "testing"
+
+func TestQMSUpdateUpdateType(t *testing.T) {
+	cases := []struct {
+		name      string
+		operation string
+		wantType  string
+		wantKnown bool
+	}{
+		{name: "defaults to SET when unset", operation: "", wantType: "SET", wantKnown: true},
+		{name: "set", operation: "set", wantType: "SET", wantKnown: true},
+		{name: "add is case-insensitive", operation: "ADD", wantType: "ADD", wantKnown: true},
+		{name: "sub is case-insensitive", operation: "Sub", wantType: "SUB", wantKnown: true},
+		{name: "unknown operation", operation: "multiply", wantType: "", wantKnown: false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			update := QMSUpdate{Operation: c.operation}
+			gotType, gotKnown := update.UpdateType()
+			if gotKnown != c.wantKnown {
+				t.Fatalf("UpdateType() ok = %v, want %v", gotKnown, c.wantKnown)
+			}
+			if gotType != c.wantType {
+				t.Fatalf("UpdateType() = %q, want %q", gotType, c.wantType)
+			}
+		})
+	}
+}
+
+func TestQMSUpdateParseValue(t *testing.T) {
+	cases := []struct {
+		name    string
+		value   string
+		want    float64
+		wantErr bool
+	}{
+		{name: "integer", value: "42", want: 42},
+		{name: "decimal", value: "3.5", want: 3.5},
+		{name: "negative", value: "-1.25", want: -1.25},
+		{name: "empty is unparseable", value: "", wantErr: true},
+		{name: "non-numeric is unparseable", value: "not-a-number", wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			update := QMSUpdate{Value: c.value}
+			got, err := update.ParseValue()
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("ParseValue(%q): expected an error", c.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseValue(%q): %s", c.value, err)
+			}
+			if got != c.want {
+				t.Fatalf("ParseValue(%q) = %v, want %v", c.value, got, c.want)
+			}
+		})
+	}
+}
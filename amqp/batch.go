@@ -0,0 +1,137 @@
+package amqp
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cyverse-de/qms-adapter/httpsrv"
+	"github.com/cyverse-de/qms-adapter/qms"
+	"github.com/streadway/amqp"
+)
+
+// pendingUpdate couples a decoded QMSUpdate with the delivery it arrived on, so the batcher can
+// ack or nack the original message once the batch it was folded into has been submitted.
+type pendingUpdate struct {
+	delivery amqp.Delivery
+	update   *QMSUpdate
+}
+
+// BatchHandlerFn submits a batch of decoded updates to QMS. A non-nil return causes every
+// delivery in the batch to be dead-lettered.
+type BatchHandlerFn func(ctx context.Context, updates []*QMSUpdate) error
+
+// batcher folds individual deliveries into batches of up to `size` updates, flushing early if
+// `window` elapses before a batch fills up. Each batch is handed off to a qms.Dispatcher so that
+// a slow QMS round-trip doesn't stall the accumulation of the next batch.
+type batcher struct {
+	handler    BatchHandlerFn
+	size       int
+	window     time.Duration
+	dispatcher *qms.Dispatcher
+
+	in       chan pendingUpdate
+	done     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func newBatcher(handler BatchHandlerFn, size int, window time.Duration, dispatcher *qms.Dispatcher) *batcher {
+	if size < 1 {
+		size = 1
+	}
+	if window <= 0 {
+		window = time.Second
+	}
+
+	return &batcher{
+		handler:    handler,
+		size:       size,
+		window:     window,
+		dispatcher: dispatcher,
+		in:         make(chan pendingUpdate, size),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+}
+
+// enqueue adds a pendingUpdate to the current batch. It blocks if the batcher has fallen behind.
+func (b *batcher) enqueue(p pendingUpdate) {
+	b.in <- p
+}
+
+// stop flushes any partial batch and waits for the batcher's run loop to exit. It is safe to call
+// more than once. Callers that also close the dispatcher (e.g. AMQP.Drain) must call stop first,
+// since run may still be blocked inside dispatch's call to dispatcher.Submit when done closes.
+func (b *batcher) stop() {
+	b.stopOnce.Do(func() { close(b.done) })
+	<-b.stopped
+}
+
+func (b *batcher) run() {
+	defer close(b.stopped)
+
+	timer := time.NewTimer(b.window)
+	defer timer.Stop()
+
+	batch := make([]pendingUpdate, 0, b.size)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		b.dispatch(batch)
+		batch = make([]pendingUpdate, 0, b.size)
+	}
+
+	for {
+		select {
+		case p := <-b.in:
+			batch = append(batch, p)
+			if len(batch) >= b.size {
+				flush()
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(b.window)
+			}
+
+		case <-timer.C:
+			flush()
+			timer.Reset(b.window)
+
+		case <-b.done:
+			flush()
+			return
+		}
+	}
+}
+
+// dispatch hands batch off to the dispatcher's worker pool, which submits it to QMS and then
+// acks or nacks every delivery in the batch once that's done.
+func (b *batcher) dispatch(batch []pendingUpdate) {
+	b.dispatcher.Submit(func(ctx context.Context) {
+		updates := make([]*QMSUpdate, len(batch))
+		for i, p := range batch {
+			updates[i] = p.update
+		}
+
+		if err := b.handler(ctx, updates); err != nil {
+			log.Errorf("batch of %d update(s) failed, dead-lettering: %s", len(batch), err)
+			httpsrv.MessagesDeadLettered.Add(float64(len(batch)))
+			for _, p := range batch {
+				if nackErr := p.delivery.Nack(false, false); nackErr != nil {
+					log.Error(nackErr)
+				}
+			}
+			return
+		}
+
+		httpsrv.MessagesForwarded.Add(float64(len(batch)))
+		for _, p := range batch {
+			if ackErr := p.delivery.Ack(false); ackErr != nil {
+				log.Error(ackErr)
+			}
+		}
+	})
+}
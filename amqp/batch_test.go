@@ -0,0 +1,86 @@
+package amqp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/qms-adapter/qms"
+	"github.com/streadway/amqp"
+)
+
+// fakeAcknowledger is a no-op amqp.Acknowledger, letting pendingUpdate.delivery.Ack/Nack/Reject be
+// called from tests without a real broker connection.
+type fakeAcknowledger struct{}
+
+func (fakeAcknowledger) Ack(tag uint64, multiple bool) error           { return nil }
+func (fakeAcknowledger) Nack(tag uint64, multiple, requeue bool) error { return nil }
+func (fakeAcknowledger) Reject(tag uint64, requeue bool) error         { return nil }
+
+func newTestPendingUpdate() pendingUpdate {
+	return pendingUpdate{
+		delivery: amqp.Delivery{Acknowledger: fakeAcknowledger{}},
+		update:   &QMSUpdate{},
+	}
+}
+
+// TestBatcherStopDoesNotRaceDispatcherClose reproduces the shutdown scenario where all dispatcher
+// workers are busy and its queue is full, so run() is blocked inside dispatch's call to
+// dispatcher.Submit when stop is called. stop must not return until run has actually exited, so
+// that a caller closing the dispatcher right after (as AMQP.Drain does) can never race run's
+// blocked send on the now-closed jobs channel.
+func TestBatcherStopDoesNotRaceDispatcherClose(t *testing.T) {
+	block := make(chan struct{})
+	handlerCalls := make(chan struct{}, 3)
+
+	handler := func(ctx context.Context, updates []*QMSUpdate) error {
+		handlerCalls <- struct{}{}
+		<-block
+		return nil
+	}
+
+	// One worker, a one-slot queue: the first batch occupies the worker, the second fills the
+	// queue, and the third forces run's dispatch call to block inside Submit.
+	dispatcher := qms.NewDispatcher(1, 1)
+	b := newBatcher(handler, 1, time.Hour, dispatcher)
+	go b.run()
+
+	for i := 0; i < 3; i++ {
+		b.enqueue(newTestPendingUpdate())
+	}
+
+	// Give run a chance to flush all three batches and block on the third Submit call.
+	<-handlerCalls
+	time.Sleep(50 * time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		b.stop()
+		close(stopped)
+	}()
+
+	// stop must still be blocked: run hasn't been able to exit yet because dispatch is stuck
+	// sending the third batch's job into the full queue, and the handler is still blocked.
+	select {
+	case <-stopped:
+		t.Fatal("stop returned before run could exit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(block)
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("stop did not return after the handler unblocked")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// This must not race with any in-flight send on dispatcher.jobs; stop having already
+	// returned guarantees run is no longer trying to send.
+	if err := dispatcher.Close(ctx); err != nil {
+		t.Fatalf("dispatcher.Close: %s", err)
+	}
+}
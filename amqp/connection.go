@@ -0,0 +1,118 @@
+package amqp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/streadway/amqp"
+)
+
+// dial connects to the broker, using TLS if the configuration calls for it.
+func dial(config *Configuration) (*amqp.Connection, error) {
+	if config.TLSCACert == "" && config.TLSClientCert == "" && config.TLSClientKey == "" && !config.InsecureSkipVerify {
+		log.Debug("dialing AMQP broker")
+		return amqp.Dial(config.URI)
+	}
+
+	tlsConfig, err := buildTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug("dialing AMQP broker over TLS")
+
+	return amqp.DialTLS(config.URI, tlsConfig)
+}
+
+func buildTLSConfig(config *Configuration) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify} //nolint:gosec
+
+	if config.TLSCACert != "" {
+		caCert, err := os.ReadFile(config.TLSCACert)
+		if err != nil {
+			return nil, fmt.Errorf("reading TLS CA cert %s: %w", config.TLSCACert, err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", config.TLSCACert)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.TLSClientCert != "" || config.TLSClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(config.TLSClientCert, config.TLSClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading TLS client cert/key: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// Event describes a notable event observed on the underlying broker connection.
+type Event struct {
+	Kind   string // "blocked", "unblocked", or "closed"
+	Reason string
+}
+
+// watchConnection registers NotifyBlocked and NotifyClose handlers on the broker connection and
+// forwards what they report onto a.events, so operators can tell TCP-level backpressure from
+// RabbitMQ's memory watermark apart from a broker-initiated shutdown.
+func (a *AMQP) watchConnection() {
+	blocked := a.conn.NotifyBlocked(make(chan amqp.Blocking, 1))
+	closed := a.conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	go func() {
+		for {
+			select {
+			case b, ok := <-blocked:
+				if !ok {
+					return
+				}
+
+				if b.Active {
+					log.Warnf("AMQP connection blocked by broker: %s", b.Reason)
+					a.emit(Event{Kind: "blocked", Reason: b.Reason})
+				} else {
+					log.Info("AMQP connection unblocked by broker")
+					a.emit(Event{Kind: "unblocked"})
+				}
+
+			case err, ok := <-closed:
+				if !ok {
+					return
+				}
+
+				if err != nil {
+					log.Errorf("AMQP connection closed by broker: %s", err)
+					a.emit(Event{Kind: "closed", Reason: err.Error()})
+				} else {
+					log.Info("AMQP connection closed")
+					a.emit(Event{Kind: "closed"})
+				}
+
+				return
+			}
+		}
+	}()
+}
+
+func (a *AMQP) emit(e Event) {
+	select {
+	case a.events <- e:
+	default:
+		log.Warnf("events channel is full, dropping %s event", e.Kind)
+	}
+}
+
+// Events returns a channel of connection-level events (blocked, unblocked, closed) observed on
+// the underlying AMQP connection.
+func (a *AMQP) Events() <-chan Event {
+	return a.events
+}
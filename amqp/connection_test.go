@@ -0,0 +1,123 @@
+package amqp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert generates a minimal self-signed certificate/key pair and writes them as PEM
+// files under dir, returning their paths.
+func writeTestCert(t *testing.T, dir, name string) (certPath, keyPath string) {
+	t.Helper()
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("creating certificate: %s", err)
+	}
+
+	certPath = filepath.Join(dir, name+".crt")
+	keyPath = filepath.Join(dir, name+".key")
+
+	certOut, err := os.Create(certPath)
+	if err != nil {
+		t.Fatalf("creating cert file: %s", err)
+	}
+	defer certOut.Close()
+	if err := pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+		t.Fatalf("encoding cert: %s", err)
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatalf("marshalling key: %s", err)
+	}
+
+	keyOut, err := os.Create(keyPath)
+	if err != nil {
+		t.Fatalf("creating key file: %s", err)
+	}
+	defer keyOut.Close()
+	if err := pem.Encode(keyOut, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}); err != nil {
+		t.Fatalf("encoding key: %s", err)
+	}
+
+	return certPath, keyPath
+}
+
+func TestBuildTLSConfigInsecureSkipVerifyOnly(t *testing.T) {
+	tlsConfig, err := buildTLSConfig(&Configuration{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("buildTLSConfig: %s", err)
+	}
+	if !tlsConfig.InsecureSkipVerify {
+		t.Fatal("expected InsecureSkipVerify to be true")
+	}
+	if tlsConfig.RootCAs != nil || len(tlsConfig.Certificates) != 0 {
+		t.Fatal("expected no CA pool or client certificates to be set")
+	}
+}
+
+func TestBuildTLSConfigWithCACert(t *testing.T) {
+	dir := t.TempDir()
+	caCertPath, _ := writeTestCert(t, dir, "ca")
+
+	tlsConfig, err := buildTLSConfig(&Configuration{TLSCACert: caCertPath})
+	if err != nil {
+		t.Fatalf("buildTLSConfig: %s", err)
+	}
+	if tlsConfig.RootCAs == nil {
+		t.Fatal("expected RootCAs to be populated")
+	}
+}
+
+func TestBuildTLSConfigWithClientCert(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeTestCert(t, dir, "client")
+
+	tlsConfig, err := buildTLSConfig(&Configuration{TLSClientCert: certPath, TLSClientKey: keyPath})
+	if err != nil {
+		t.Fatalf("buildTLSConfig: %s", err)
+	}
+	if len(tlsConfig.Certificates) != 1 {
+		t.Fatalf("expected 1 client certificate, got %d", len(tlsConfig.Certificates))
+	}
+}
+
+func TestBuildTLSConfigMissingCACertFile(t *testing.T) {
+	_, err := buildTLSConfig(&Configuration{TLSCACert: "/nonexistent/ca.crt"})
+	if err == nil {
+		t.Fatal("expected an error for a missing CA cert file")
+	}
+}
+
+func TestBuildTLSConfigMissingClientKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	certPath, _ := writeTestCert(t, dir, "client")
+
+	_, err := buildTLSConfig(&Configuration{TLSClientCert: certPath, TLSClientKey: "/nonexistent/client.key"})
+	if err == nil {
+		t.Fatal("expected an error for a missing client key file")
+	}
+}
@@ -0,0 +1,55 @@
+package httpsrv
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Metrics counters and histograms, registered with the default Prometheus registry and served
+// at /metrics.
+var (
+	MessagesReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "qms_adapter",
+		Name:      "messages_received_total",
+		Help:      "Total number of AMQP deliveries received.",
+	})
+
+	MessagesDecoded = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "qms_adapter",
+		Name:      "messages_decoded_total",
+		Help:      "Total number of AMQP deliveries successfully decoded into a QMSUpdate with a known operation and a numeric value.",
+	})
+
+	MessagesForwarded = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "qms_adapter",
+		Name:      "messages_forwarded_total",
+		Help:      "Total number of updates successfully submitted to QMS.",
+	})
+
+	MessagesRetried = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "qms_adapter",
+		Name:      "messages_retried_total",
+		Help:      "Total number of retried QMS batch submit attempts.",
+	})
+
+	MessagesDeadLettered = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "qms_adapter",
+		Name:      "messages_dead_lettered_total",
+		Help:      "Total number of deliveries rejected to the dead-letter exchange.",
+	})
+
+	QMSRequestDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "qms_adapter",
+		Name:      "qms_request_duration_seconds",
+		Help:      "Latency of QMS batch submit requests.",
+		Buckets:   prometheus.DefBuckets,
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		MessagesReceived,
+		MessagesDecoded,
+		MessagesForwarded,
+		MessagesRetried,
+		MessagesDeadLettered,
+		QMSRequestDuration,
+	)
+}
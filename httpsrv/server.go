@@ -0,0 +1,90 @@
+// Package httpsrv contains the admin HTTP server exposing health, readiness, metrics, and pprof
+// endpoints for operators.
+package httpsrv
+
+import (
+	"context"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/cyverse-de/qms-adapter/logging"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+var log = logging.Log.WithFields(logrus.Fields{"package": "httpsrv"})
+
+// Server exposes the service's admin, health, and metrics HTTP endpoints.
+type Server struct {
+	httpServer *http.Server
+	status     *Status
+	staleAfter time.Duration
+}
+
+// New returns a *Server that will listen on addr (e.g. ":8080"). Readiness is reported from
+// status; staleAfter is how long it may have been since the last successful QMS submission
+// before readiness starts failing.
+func New(addr string, status *Status, staleAfter time.Duration) *Server {
+	s := &Server{
+		status:     status,
+		staleAfter: staleAfter,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	mux.Handle("/metrics", promhttp.Handler())
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	s.httpServer = &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	return s
+}
+
+// Start runs the HTTP server until Shutdown is called or it fails to serve. Intended to be run
+// in its own goroutine.
+func (s *Server) Start() {
+	log.Infof("starting admin HTTP server on %s", s.httpServer.Addr)
+
+	if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Error(err)
+	}
+}
+
+// Shutdown gracefully stops the HTTP server.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
+
+func (s *Server) handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
+
+func (s *Server) handleReadyz(w http.ResponseWriter, _ *http.Request) {
+	ready, reason := s.status.Ready(s.staleAfter)
+	if !ready {
+		writeError(w, http.StatusServiceUnavailable, reason)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	errResp := logging.ErrorResponse{Message: message, ErrorCode: status}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(errResp.ErrorBytes())
+}
@@ -0,0 +1,55 @@
+package httpsrv
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Status tracks the health signals that back the /readyz endpoint: whether the service is
+// currently connected to the AMQP broker, and how long it's been since QMS last accepted a
+// batch.
+type Status struct {
+	mu sync.RWMutex
+
+	amqpConnected  bool
+	lastQMSSuccess time.Time
+}
+
+// NewStatus returns an empty *Status. The service is not ready until SetAMQPConnected(true) has
+// been called at least once.
+func NewStatus() *Status {
+	return &Status{}
+}
+
+// SetAMQPConnected records whether the service currently has a live AMQP connection.
+func (s *Status) SetAMQPConnected(connected bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.amqpConnected = connected
+}
+
+// RecordQMSSuccess notes that a batch was just accepted by QMS.
+func (s *Status) RecordQMSSuccess(at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastQMSSuccess = at
+}
+
+// Ready reports whether the service should be considered ready to receive traffic: it must be
+// connected to AMQP, and if it has ever submitted a batch to QMS, that must have last succeeded
+// within staleAfter. It returns false and an explanatory reason otherwise.
+func (s *Status) Ready(staleAfter time.Duration) (bool, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.amqpConnected {
+		return false, "not connected to the AMQP broker"
+	}
+
+	if !s.lastQMSSuccess.IsZero() && time.Since(s.lastQMSSuccess) > staleAfter {
+		return false, fmt.Sprintf("no successful QMS update since %s", s.lastQMSSuccess.Format(time.RFC3339))
+	}
+
+	return true, ""
+}
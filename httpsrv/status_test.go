@@ -0,0 +1,65 @@
+package httpsrv
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatusNotReadyBeforeAMQPConnected(t *testing.T) {
+	status := NewStatus()
+
+	ready, reason := status.Ready(time.Minute)
+	if ready {
+		t.Fatal("expected not ready before SetAMQPConnected")
+	}
+	if reason == "" {
+		t.Fatal("expected a non-empty reason")
+	}
+}
+
+func TestStatusReadyWithNoQMSSubmissionsYet(t *testing.T) {
+	status := NewStatus()
+	status.SetAMQPConnected(true)
+
+	ready, reason := status.Ready(time.Minute)
+	if !ready {
+		t.Fatalf("expected ready, got reason %q", reason)
+	}
+}
+
+func TestStatusReadyWithRecentQMSSuccess(t *testing.T) {
+	status := NewStatus()
+	status.SetAMQPConnected(true)
+	status.RecordQMSSuccess(time.Now())
+
+	ready, reason := status.Ready(time.Minute)
+	if !ready {
+		t.Fatalf("expected ready, got reason %q", reason)
+	}
+}
+
+func TestStatusNotReadyWithStaleQMSSuccess(t *testing.T) {
+	status := NewStatus()
+	status.SetAMQPConnected(true)
+	status.RecordQMSSuccess(time.Now().Add(-time.Hour))
+
+	ready, reason := status.Ready(time.Minute)
+	if ready {
+		t.Fatal("expected not ready once lastQMSSuccess exceeds staleAfter")
+	}
+	if reason == "" {
+		t.Fatal("expected a non-empty reason")
+	}
+}
+
+func TestStatusNotReadyWhenAMQPDisconnectsAfterQMSSuccess(t *testing.T) {
+	status := NewStatus()
+	status.SetAMQPConnected(true)
+	status.RecordQMSSuccess(time.Now())
+	status.SetAMQPConnected(false)
+
+	ready, _ := status.Ready(time.Minute)
+	if ready {
+		t.Fatal("expected not ready once AMQP disconnects, regardless of QMS staleness")
+	}
+}
@@ -1,21 +1,22 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
-	"strconv"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/cyverse-de/configurate"
 	"github.com/cyverse-de/go-mod/otelutils"
 	"github.com/cyverse-de/qms-adapter/amqp"
+	"github.com/cyverse-de/qms-adapter/httpsrv"
 	"github.com/cyverse-de/qms-adapter/logging"
+	"github.com/cyverse-de/qms-adapter/qms"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 
@@ -29,83 +30,64 @@ var log = logging.Log.WithFields(logrus.Fields{"package": "main"})
 
 // Configuration contains app-wide configuration settings.
 type Configuration struct {
-	QMSEnabled  bool
-	QMSEndpoint string
-	UserDomain  string
+	QMSEnabled bool
+	UserDomain string
 }
 
-// QMSRequestBody contains the fields we send to QMS for every usage update.
-type QMSRequestBody struct {
-	Username     string  `json:"username"`
-	ResourceName string  `json:"resource_name"`
-	UsageValue   float64 `json:"usage_value"`
-	UpdateType   string  `json:"update_type"`
-}
-
-func getHandler(config *Configuration) amqp.HandlerFn {
-	return func(ctx context.Context, update *amqp.QMSUpdate) {
-		log = log.WithFields(logrus.Fields{"context": "update handler"})
+// getHandler returns the amqp.BatchHandlerFn that translates a batch of decoded AMQP updates
+// into QMS usage update requests and submits them to batchClient, retrying with backoff up to
+// maxRetries times. A non-nil return dead-letters every update in the batch.
+func getHandler(config *Configuration, batchClient *qms.BatchClient, maxRetries int, status *httpsrv.Status) amqp.BatchHandlerFn {
+	return func(ctx context.Context, updates []*amqp.QMSUpdate) error {
+		log := log.WithFields(logrus.Fields{"context": "update handler"})
 
 		log.Debugf("QMS enabled: %v", config.QMSEnabled)
 
-		fullDomain := fmt.Sprintf("@%s", config.UserDomain)
-
-		apiURL, err := url.Parse(config.QMSEndpoint)
-		if err != nil {
-			log.Error(err)
-			return
-		}
-
-		if config.QMSEnabled {
-			parsedValue, err := strconv.ParseFloat(update.Value, 64)
-			if err != nil {
-				log.Error(err)
-				return
-			}
-
-			update.Username = strings.TrimSuffix(update.Username, fullDomain)
-
-			body := &QMSRequestBody{
-				ResourceName: update.Attribute,
-				Username:     update.Username,
-				UsageValue:   parsedValue,
-				UpdateType:   "SET",
-			}
-
-			marshalled, err := json.Marshal(body)
-			if err != nil {
-				log.Error(err)
-				return
+		if !config.QMSEnabled {
+			for _, update := range updates {
+				log.Infof("%+v", update)
 			}
+			return nil
+		}
 
-			buf := bytes.NewBuffer(marshalled)
+		fullDomain := fmt.Sprintf("@%s", config.UserDomain)
 
-			updateRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL.String(), buf)
+		body := make([]qms.RequestBody, 0, len(updates))
+		for _, update := range updates {
+			// recv() already validates Value and Operation before a message reaches the
+			// batcher, so failures here indicate a bug rather than bad input; fail the whole
+			// batch since there's no single delivery left to dead-letter on its own.
+			parsedValue, err := update.ParseValue()
 			if err != nil {
 				log.Error(err)
-				return
+				return err
 			}
 
-			updateRequest.Header.Set("Content-Type", "application/json")
-
-			log.Debugf("url: %s", updateRequest.URL.String())
-
-			postResp, err := client.Do(updateRequest)
-			if err != nil {
+			updateType, ok := update.UpdateType()
+			if !ok {
+				// recv() already dead-letters unknown operations, so this update shouldn't
+				// have reached the handler; fail the batch rather than guess at intent.
+				err := fmt.Errorf("unknown operation %q for user %s", update.Operation, update.Username)
 				log.Error(err)
-				return
+				return err
 			}
 
-			postRespBody, err := io.ReadAll(postResp.Body)
-			if err != nil {
-				log.Error(err)
-				return
-			}
+			body = append(body, qms.RequestBody{
+				ResourceName:  update.Attribute,
+				Username:      strings.TrimSuffix(update.Username, fullDomain),
+				UsageValue:    parsedValue,
+				UpdateType:    updateType,
+				EffectiveDate: update.EffectiveDate,
+			})
+		}
 
-			log.Infof("URL: %s, status code: %d, response: %s", updateRequest.URL.String(), postResp.StatusCode, postRespBody)
-		} else {
-			log.Infof("%+v", update)
+		err := qms.WithRetry(ctx, maxRetries, func() error {
+			return batchClient.Submit(ctx, body)
+		})
+		if err == nil {
+			status.RecordQMSSuccess(time.Now())
 		}
+		return err
 	}
 }
 
@@ -114,11 +96,13 @@ func main() {
 		err    error
 		config *viper.Viper
 
-		configPath = flag.String("config", "/etc/iplant/de/jobservices.yml", "Full path to the configuration file")
-		queue      = flag.String("queue", "qms-adapter", "The AMQP queue name for this service")
-		reconnect  = flag.Bool("reconnect", false, "Whether the AMQP client should reconnect on failure")
-		logLevel   = flag.String("log-level", "info", "One of trace, debug, info, warn, error, fatal, or panic")
-		routingKey = flag.String("routing-key", "qms.usages", "The routing key for incoming AMQP messages")
+		configPath   = flag.String("config", "/etc/iplant/de/jobservices.yml", "Full path to the configuration file")
+		queue        = flag.String("queue", "qms-adapter", "The AMQP queue name for this service")
+		reconnect    = flag.Bool("reconnect", false, "Whether the AMQP client should reconnect on failure")
+		logLevel     = flag.String("log-level", "info", "One of trace, debug, info, warn, error, fatal, or panic")
+		routingKey   = flag.String("routing-key", "qms.usages", "The routing key for incoming AMQP messages")
+		listenAddr   = flag.String("listen-addr", ":60000", "The address the admin HTTP server listens on")
+		drainTimeout = flag.Duration("drain-timeout", 30*time.Second, "How long to wait for in-flight QMS submissions to finish on shutdown")
 	)
 
 	flag.Parse()
@@ -163,32 +147,68 @@ func main() {
 		log.Fatal("qms.base must be set if qms.enabled is true")
 	}
 
-	qmsUsage := config.GetString("qms.usage")
-	if qmsEnabled && qmsUsage == "" {
-		log.Fatal("qms.usage must be set if qms.enabled is true")
+	qmsUsageBatch := config.GetString("qms.usageBatch")
+	if qmsEnabled && qmsUsageBatch == "" {
+		log.Fatal("qms.usageBatch must be set if qms.enabled is true")
 	}
 
-	qmsEndpoint, err := url.Parse(qmsBase)
+	qmsBatchEndpoint, err := url.Parse(qmsBase)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	qmsEndpoint.Path = qmsUsage
+	qmsBatchEndpoint.Path = qmsUsageBatch
 
 	configuration := Configuration{
-		QMSEnabled:  qmsEnabled,
-		QMSEndpoint: qmsEndpoint.String(),
-		UserDomain:  userDomain,
+		QMSEnabled: qmsEnabled,
+		UserDomain: userDomain,
+	}
+
+	amqpPrefetchCount := config.GetInt("amqp.prefetchCount")
+
+	amqpMaxRetries := config.GetInt("amqp.maxRetries")
+	if amqpMaxRetries == 0 {
+		amqpMaxRetries = 5
+	}
+
+	amqpBatchSize := config.GetInt("amqp.batchSize")
+	if amqpBatchSize == 0 {
+		amqpBatchSize = 100
+	}
+
+	amqpBatchWindow := config.GetDuration("amqp.batchWindow")
+	if amqpBatchWindow == 0 {
+		amqpBatchWindow = 5 * time.Second
+	}
+
+	amqpWorkers := config.GetInt("amqp.workers")
+	if amqpWorkers == 0 {
+		amqpWorkers = 4
+	}
+
+	amqpDispatchQueueSize := config.GetInt("amqp.dispatchQueueSize")
+	if amqpDispatchQueueSize == 0 {
+		amqpDispatchQueueSize = amqpWorkers * 4
 	}
 
 	amqpConfig := amqp.Configuration{
-		URI:           amqpURI,
-		Exchange:      amqpExchange,
-		ExchangeType:  amqpExchangeType,
-		RoutingKey:    *routingKey,
-		Reconnect:     *reconnect,
-		Queue:         *queue,
-		PrefetchCount: 0,
+		URI:                amqpURI,
+		Exchange:           amqpExchange,
+		ExchangeType:       amqpExchangeType,
+		RoutingKey:         *routingKey,
+		Reconnect:          *reconnect,
+		Queue:              *queue,
+		PrefetchCount:      amqpPrefetchCount,
+		BatchSize:          amqpBatchSize,
+		BatchWindow:        amqpBatchWindow,
+		MaxRetries:         amqpMaxRetries,
+		DeadLetterExchange: config.GetString("amqp.deadLetterExchange"),
+		TLSCACert:          config.GetString("amqp.tls.caCert"),
+		TLSClientCert:      config.GetString("amqp.tls.clientCert"),
+		TLSClientKey:       config.GetString("amqp.tls.clientKey"),
+		InsecureSkipVerify: config.GetBool("amqp.tls.insecureSkipVerify"),
+		Workers:            amqpWorkers,
+		DispatchQueueSize:  amqpDispatchQueueSize,
 	}
 
 	log.Infof("AMQP exchange name: %s", amqpConfig.Exchange)
@@ -197,14 +217,57 @@ func main() {
 	log.Infof("AMQP queue name: %s", amqpConfig.Queue)
 	log.Infof("AMQP prefetch amount %d", amqpConfig.PrefetchCount)
 	log.Infof("AMQP routing key: %s", amqpConfig.RoutingKey)
+	log.Infof("AMQP batch size: %d", amqpConfig.BatchSize)
+	log.Infof("AMQP batch window: %s", amqpConfig.BatchWindow)
+	log.Infof("AMQP max retries: %d", amqpConfig.MaxRetries)
+	log.Infof("AMQP dead-letter exchange: %s", amqpConfig.DeadLetterExchange)
 
-	amqpClient, err := amqp.New(&amqpConfig, getHandler(&configuration))
+	batchClient := qms.NewBatchClient(&client, qmsBatchEndpoint.String())
+
+	status := httpsrv.NewStatus()
+
+	readyStaleAfter := config.GetDuration("http.readyStaleAfter")
+	if readyStaleAfter == 0 {
+		readyStaleAfter = 10 * time.Minute
+	}
+
+	adminServer := httpsrv.New(*listenAddr, status, readyStaleAfter)
+	go adminServer.Start()
+
+	amqpClient, err := amqp.New(&amqpConfig, getHandler(&configuration, batchClient, amqpConfig.MaxRetries, status))
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer amqpClient.Close()
 
+	status.SetAMQPConnected(true)
+
+	go func() {
+		eventLog := log.WithFields(logrus.Fields{"context": "amqp event"})
+		for event := range amqpClient.Events() {
+			eventLog.Warnf("%s: %s", event.Kind, event.Reason)
+			if event.Kind == "closed" {
+				status.SetAMQPConnected(false)
+			}
+		}
+	}()
+
 	log.Info("done connecting to the AMQP broker")
 
-	select {}
+	sigCtx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+
+	<-sigCtx.Done()
+	log.Info("shutdown signal received, draining in-flight work")
+
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), *drainTimeout)
+	defer cancelDrain()
+
+	if err := amqpClient.Drain(drainCtx); err != nil {
+		log.Warnf("in-flight work did not drain cleanly: %s", err)
+	}
+
+	if err := adminServer.Shutdown(drainCtx); err != nil {
+		log.Warnf("admin HTTP server did not shut down cleanly: %s", err)
+	}
 }
@@ -0,0 +1,79 @@
+// Package qms contains the client used to forward usage updates to the QMS service.
+package qms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/cyverse-de/qms-adapter/httpsrv"
+	"github.com/cyverse-de/qms-adapter/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+var log = logging.Log.WithFields(logrus.Fields{"package": "qms"})
+
+// RequestBody contains the fields sent to QMS for a single usage update.
+type RequestBody struct {
+	Username     string  `json:"username"`
+	ResourceName string  `json:"resource_name"`
+	UsageValue   float64 `json:"usage_value"`
+	UpdateType   string  `json:"update_type"`
+
+	// EffectiveDate, when non-empty, tells QMS to apply this update against that point in time
+	// instead of the time the request was received.
+	EffectiveDate string `json:"effective_date,omitempty"`
+}
+
+// BatchClient POSTs batches of usage updates to the QMS batch usage endpoint.
+type BatchClient struct {
+	httpClient *http.Client
+	endpoint   string
+}
+
+// NewBatchClient returns a *BatchClient that POSTs to endpoint using httpClient.
+func NewBatchClient(httpClient *http.Client, endpoint string) *BatchClient {
+	return &BatchClient{
+		httpClient: httpClient,
+		endpoint:   endpoint,
+	}
+}
+
+// Submit POSTs the given batch of usage updates to QMS as a single request. An error is
+// returned if the request could not be sent, or if QMS did not respond with a 2xx status code.
+func (c *BatchClient) Submit(ctx context.Context, batch []RequestBody) error {
+	marshalled, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("marshalling batch of %d update(s): %w", len(batch), err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewBuffer(marshalled))
+	if err != nil {
+		return fmt.Errorf("building batch request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	log.Debugf("submitting batch of %d update(s) to %s", len(batch), c.endpoint)
+
+	timer := prometheus.NewTimer(httpsrv.QMSRequestDuration)
+	resp, err := c.httpClient.Do(req)
+	timer.ObserveDuration()
+	if err != nil {
+		return fmt.Errorf("submitting batch to %s: %w", c.endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("QMS returned status %d for batch of %d update(s): %s", resp.StatusCode, len(batch), respBody)
+	}
+
+	log.Infof("url: %s, status code: %d, response: %s", c.endpoint, resp.StatusCode, respBody)
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package qms
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBatchClientSubmitSuccess(t *testing.T) {
+	var received []RequestBody
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("decoding request body: %s", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewBatchClient(server.Client(), server.URL)
+
+	batch := []RequestBody{{Username: "alice", ResourceName: "cpu.hours", UsageValue: 1.5, UpdateType: "SET"}}
+	if err := client.Submit(context.Background(), batch); err != nil {
+		t.Fatalf("Submit: %s", err)
+	}
+	if len(received) != 1 || received[0].Username != "alice" {
+		t.Fatalf("unexpected request body received by server: %+v", received)
+	}
+}
+
+func TestBatchClientSubmitNonSuccessStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("boom"))
+	}))
+	defer server.Close()
+
+	client := NewBatchClient(server.Client(), server.URL)
+
+	err := client.Submit(context.Background(), []RequestBody{{Username: "alice"}})
+	if err == nil {
+		t.Fatal("expected an error for a non-2xx response")
+	}
+}
+
+func TestBatchClientSubmitNetworkError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	client := NewBatchClient(server.Client(), server.URL)
+
+	err := client.Submit(context.Background(), []RequestBody{{Username: "alice"}})
+	if err == nil {
+		t.Fatal("expected an error when the server is unreachable")
+	}
+}
@@ -0,0 +1,71 @@
+package qms
+
+import (
+	"context"
+	"sync"
+)
+
+// Job is a unit of work run by a Dispatcher.
+type Job func(ctx context.Context)
+
+// Dispatcher runs Jobs submitted to it across a bounded pool of worker goroutines, so a slow QMS
+// round-trip no longer blocks the next one from starting the way a single synchronous handler
+// call would.
+type Dispatcher struct {
+	jobs chan Job
+	wg   sync.WaitGroup
+}
+
+// NewDispatcher starts a Dispatcher with the given number of workers, each pulling Jobs off a
+// channel buffered to queueSize.
+func NewDispatcher(workers, queueSize int) *Dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 1 {
+		queueSize = 1
+	}
+
+	d := &Dispatcher{
+		jobs: make(chan Job, queueSize),
+	}
+
+	d.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go d.worker()
+	}
+
+	return d
+}
+
+func (d *Dispatcher) worker() {
+	defer d.wg.Done()
+	for job := range d.jobs {
+		job(context.Background())
+	}
+}
+
+// Submit enqueues a Job for a worker to run. It blocks if every worker is busy and the queue is
+// full.
+func (d *Dispatcher) Submit(job Job) {
+	d.jobs <- job
+}
+
+// Close stops accepting new Jobs and waits for queued and in-flight Jobs to finish, up to ctx's
+// deadline.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	close(d.jobs)
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,46 @@
+package qms
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/cyverse-de/qms-adapter/httpsrv"
+)
+
+// WithRetry calls fn, retrying with exponential backoff and jitter if it returns an error, up to
+// maxRetries additional attempts. It returns nil as soon as fn succeeds, or fn's last error once
+// all attempts are exhausted. A maxRetries of 0 means fn is tried exactly once.
+func WithRetry(ctx context.Context, maxRetries int, fn func() error) error {
+	var err error
+
+	for attempt := 0; ; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+
+		if attempt >= maxRetries {
+			return err
+		}
+
+		httpsrv.MessagesRetried.Inc()
+
+		wait := backoff(attempt)
+		log.Warnf("attempt %d of %d failed: %s; retrying in %s", attempt+1, maxRetries+1, err, wait)
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// backoff returns 100ms*2^attempt plus a random jitter of up to the same duration, so that
+// retries from many goroutines don't all land on QMS at once.
+func backoff(attempt int) time.Duration {
+	base := time.Duration(math.Pow(2, float64(attempt))) * 100 * time.Millisecond
+	jitter := time.Duration(rand.Int63n(int64(base) + 1))
+	return base + jitter
+}
@@ -0,0 +1,85 @@
+package qms
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoffBounds(t *testing.T) {
+	for attempt := 0; attempt < 6; attempt++ {
+		base := time.Duration(1<<uint(attempt)) * 100 * time.Millisecond
+		for i := 0; i < 20; i++ {
+			wait := backoff(attempt)
+			if wait < base || wait > 2*base {
+				t.Fatalf("attempt %d: backoff %s out of bounds [%s, %s]", attempt, wait, base, 2*base)
+			}
+		}
+	}
+}
+
+func TestWithRetrySucceedsWithoutRetrying(t *testing.T) {
+	calls := 0
+	err := WithRetry(context.Background(), 3, func() error {
+		calls++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithRetry: %s", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 call, got %d", calls)
+	}
+}
+
+func TestWithRetryRetriesUntilSuccess(t *testing.T) {
+	calls := 0
+	err := WithRetry(context.Background(), 3, func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithRetry: %s", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestWithRetryReturnsLastErrorAfterExhaustingAttempts(t *testing.T) {
+	calls := 0
+	wantErr := errors.New("still failing")
+	err := WithRetry(context.Background(), 2, func() error {
+		calls++
+		return wantErr
+	})
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("expected %v, got %v", wantErr, err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 calls (1 + 2 retries), got %d", calls)
+	}
+}
+
+func TestWithRetryStopsOnContextCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	calls := 0
+	err := WithRetry(ctx, 5, func() error {
+		calls++
+		if calls == 1 {
+			cancel()
+		}
+		return errors.New("failing")
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 call before cancellation was observed, got %d", calls)
+	}
+}